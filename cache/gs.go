@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+)
+
+// gsStorage stores objects in a single Google Cloud Storage bucket,
+// addressed by a gs://bucket cache URL.
+type gsStorage struct {
+	bucket *storage.BucketHandle
+	ctx    context.Context
+}
+
+func newGSStorage(u *url.URL) (Storage, error) {
+	ctx := context.Background()
+
+	var opts []option.ClientOption
+	if endpoint := endpointOverride(u); endpoint != "" {
+		// Lets callers (and tests) point the client at something other than
+		// real GCS, e.g. a local httptest server that fakes PUT/GET semantics.
+		opts = append(opts, option.WithEndpoint(endpoint))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gsStorage{
+		bucket: client.Bucket(strings.TrimPrefix(u.Host+u.Path, "/")),
+		ctx:    ctx,
+	}, nil
+}
+
+func (g *gsStorage) Upload(key string, reader io.Reader) error {
+	w := g.bucket.Object(key).NewWriter(g.ctx)
+	if _, err := io.Copy(w, reader); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (g *gsStorage) Download(key string) (io.ReadCloser, error) {
+	return g.bucket.Object(key).NewReader(g.ctx)
+}
+
+func (g *gsStorage) Exists(key string) (bool, error) {
+	_, err := g.bucket.Object(key).Attrs(g.ctx)
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
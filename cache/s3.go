@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Storage stores objects in a single S3 bucket, addressed by a s3://bucket
+// cache URL. Credentials and region are resolved the same way the AWS SDK
+// always does (environment, shared config, instance profile).
+type s3Storage struct {
+	bucket   string
+	client   *s3.S3
+	uploader *s3manager.Uploader
+}
+
+func newS3Storage(u *url.URL) (Storage, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := aws.NewConfig()
+	if endpoint := endpointOverride(u); endpoint != "" {
+		// Lets callers (and tests) point the client at something other than
+		// real S3, e.g. a local httptest server that fakes PUT/GET semantics.
+		cfg = cfg.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	return &s3Storage{
+		bucket:   strings.TrimPrefix(u.Host+u.Path, "/"),
+		client:   s3.New(sess, cfg),
+		uploader: s3manager.NewUploader(sess, func(u *s3manager.Uploader) { u.S3 = s3.New(sess, cfg) }),
+	}, nil
+}
+
+func (s *s3Storage) Upload(key string, reader io.Reader) error {
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   reader,
+	})
+	return err
+}
+
+func (s *s3Storage) Download(key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Storage) Exists(key string) (bool, error) {
+	_, err := s.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if awsErr, ok := err.(interface{ Code() string }); ok && awsErr.Code() == "NotFound" {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,63 @@
+// Package cache implements the pluggable remote storage backends used for
+// distributed build caches and artifact archives. A Storage is selected at
+// runtime by the scheme of the configured cache URL (s3://, gs://), mirroring
+// how the runner already treats local paths as the default, file-based
+// backend.
+package cache
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Storage is implemented by each supported remote backend. Keys are opaque,
+// slash-separated paths (see KeyForRef) and never include the bucket name,
+// which is derived from the configured URL.
+type Storage interface {
+	Upload(key string, reader io.Reader) error
+	Download(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+}
+
+// FromURL returns the Storage implementation registered for rawURL's scheme.
+func FromURL(rawURL string) (Storage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache url %q: %v", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Storage(u)
+	case "gs":
+		return newGSStorage(u)
+	default:
+		return nil, fmt.Errorf("unsupported cache storage scheme %q", u.Scheme)
+	}
+}
+
+// endpointOverride extracts the optional "endpoint" query parameter from a
+// cache URL (e.g. "s3://bucket?endpoint=http://localhost:1234"), letting
+// newS3Storage/newGSStorage be pointed at something other than the real
+// service — a local httptest server faking PUT/GET semantics, for example.
+func endpointOverride(u *url.URL) string {
+	return u.Query().Get("endpoint")
+}
+
+// KeyForRef builds the templated object key used for per-project, per-ref
+// cache reuse: "{project}/{ref}/{cache-key}". Passing an empty ref keeps
+// cache reuse project-wide, matching the opt-in behaviour of
+// common.Build.CacheFileForRef.
+func KeyForRef(project, ref, cacheKey string) string {
+	parts := make([]string, 0, 3)
+	if project != "" {
+		parts = append(parts, project)
+	}
+	if ref != "" {
+		parts = append(parts, ref)
+	}
+	parts = append(parts, cacheKey)
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestEndpointOverride covers the query-param parsing newS3Storage/
+// newGSStorage rely on to let a cache URL point at a test double instead of
+// the real service. A full httptest-backed integration test of
+// Upload/Download against the AWS/GCS SDKs isn't included here: it would
+// require vendoring aws-sdk-go and cloud.google.com/go/storage, which this
+// tree doesn't currently do for any dependency.
+func TestEndpointOverride(t *testing.T) {
+	cases := []struct {
+		rawURL string
+		want   string
+	}{
+		{"s3://bucket", ""},
+		{"s3://bucket?endpoint=http://localhost:1234", "http://localhost:1234"},
+		{"gs://bucket?endpoint=http://localhost:5678", "http://localhost:5678"},
+	}
+
+	for _, c := range cases {
+		u, err := url.Parse(c.rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := endpointOverride(u); got != c.want {
+			t.Errorf("endpointOverride(%q) = %q, want %q", c.rawURL, got, c.want)
+		}
+	}
+}
+
+func TestKeyForRef(t *testing.T) {
+	cases := []struct {
+		project, ref, cacheKey string
+		want                   string
+	}{
+		{"project", "master", "default", "project/master/default"},
+		{"project", "", "default", "project/default"},
+		{"", "", "default", "default"},
+	}
+
+	for _, c := range cases {
+		if got := KeyForRef(c.project, c.ref, c.cacheKey); got != c.want {
+			t.Errorf("KeyForRef(%q, %q, %q) = %q, want %q", c.project, c.ref, c.cacheKey, got, c.want)
+		}
+	}
+}
+
+func TestFromURLUnsupportedScheme(t *testing.T) {
+	if _, err := FromURL("ftp://example.com/bucket"); err == nil {
+		t.Error("expected an error for an unsupported cache storage scheme")
+	}
+}
+
+func TestFromURLInvalidURL(t *testing.T) {
+	if _, err := FromURL("://not-a-url"); err == nil {
+		t.Error("expected an error for an unparsable cache url")
+	}
+}
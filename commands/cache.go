@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"io"
+	"os"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache"
+)
+
+// CacheUpload implements the remote-storage half of the `archive` subcommand:
+// once shells.AbstractShell.archiveFiles has built the local archive at
+// localPath, this uploads it to key under the backend remoteURL resolves to.
+func CacheUpload(remoteURL, key, localPath string) error {
+	storage, err := cache.FromURL(remoteURL)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return storage.Upload(key, file)
+}
+
+// CacheDownload implements the remote-storage half of the `extract`
+// subcommand: it fetches key from the backend remoteURL resolves to and
+// writes it to localPath, for shells.AbstractShell.extractFiles to unpack.
+func CacheDownload(remoteURL, key, localPath string) error {
+	storage, err := cache.FromURL(remoteURL)
+	if err != nil {
+		return err
+	}
+
+	reader, err := storage.Download(key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return err
+}
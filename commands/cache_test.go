@@ -0,0 +1,15 @@
+package commands
+
+import "testing"
+
+func TestCacheUploadUnsupportedScheme(t *testing.T) {
+	if err := CacheUpload("ftp://example.com/bucket", "key", "/nonexistent"); err == nil {
+		t.Error("expected an error for an unsupported cache storage scheme")
+	}
+}
+
+func TestCacheDownloadUnsupportedScheme(t *testing.T) {
+	if err := CacheDownload("ftp://example.com/bucket", "key", "/tmp/wherever"); err == nil {
+		t.Error("expected an error for an unsupported cache storage scheme")
+	}
+}
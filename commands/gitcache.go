@@ -0,0 +1,118 @@
+// Package commands implements the runner-side subcommands that the shell
+// scripts generated by package shells shell out to as `runnerCommand
+// <subcommand> ...`. They run on the executor host, so they can do things
+// (write files with the right permissions, talk to cloud storage, compute
+// checksums) that the cross-platform ShellWriter abstraction can't express
+// portably.
+package commands
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// mirrorLockTimeout bounds how long GitCacheFetch will wait for another
+// process to finish initializing/fetching the same mirror before giving up.
+const mirrorLockTimeout = 5 * time.Minute
+
+// mirrorLockRetryPeriod is how often we retry acquiring the lock directory
+// while waiting on another process to release it.
+const mirrorLockRetryPeriod = 200 * time.Millisecond
+
+// withMirrorLock runs fn while holding an exclusive lock on cacheDir's
+// mirror, so that two builds of the same project starting at once can't race
+// on `git init --bare`/`git fetch` into the same directory. The lock is a
+// plain lock directory: os.Mkdir is atomic even on network filesystems,
+// which a portable flock(2)-based lock across this runner's executors is not.
+func withMirrorLock(cacheDir string, fn func() error) error {
+	lockDir := cacheDir + ".lock"
+
+	deadline := time.Now().Add(mirrorLockTimeout)
+	for {
+		err := os.Mkdir(lockDir, 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("creating mirror lock: %v", err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for mirror lock %q", lockDir)
+		}
+		time.Sleep(mirrorLockRetryPeriod)
+	}
+	defer os.Remove(lockDir)
+
+	return fn()
+}
+
+// shouldRefreshMirror reports whether a mirror is due for a fetch, given the
+// last time it succeeded (the modification time of its ".fetched" marker file
+// at markerPath) and the configured minimum period between fetches.
+func shouldRefreshMirror(markerPath string, minPeriod time.Duration) bool {
+	info, err := os.Stat(markerPath)
+	if err != nil {
+		return true
+	}
+	return time.Since(info.ModTime()) >= minPeriod
+}
+
+// GitCacheFetch implements the `git-cache-fetch` subcommand invoked by
+// shells.AbstractShell.writeMirrorCmd. It initializes cacheDir as a bare
+// mirror of url on first use, and refreshes it via `git fetch` no more than
+// once every minPeriod.
+func GitCacheFetch(cacheDir, url string, minPeriod time.Duration) error {
+	marker := cacheDir + ".fetched"
+
+	if !shouldRefreshMirror(marker, minPeriod) {
+		return nil
+	}
+
+	return withMirrorLock(cacheDir, func() error {
+		// Re-check now that we hold the lock: another process may have just
+		// finished refreshing this mirror while we were waiting for it.
+		if !shouldRefreshMirror(marker, minPeriod) {
+			return nil
+		}
+
+		if _, err := os.Stat(filepath.Join(cacheDir, "HEAD")); os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(cacheDir), 0755); err != nil {
+				return fmt.Errorf("creating cache dir: %v", err)
+			}
+			if err := runGit("", "init", "--bare", cacheDir); err != nil {
+				return fmt.Errorf("initializing mirror: %v", err)
+			}
+		}
+
+		namespace := fmt.Sprintf("%x", sha256.Sum256([]byte(url)))
+		refspec := fmt.Sprintf("*:refs/namespaces/%s/*", namespace)
+		if err := runGit(cacheDir, "fetch", "--no-write-fetch-head", "--no-recurse-submodules",
+			"--no-tags", "--prune", "--force", "--", url, refspec); err != nil {
+			return fmt.Errorf("fetching mirror: %v", err)
+		}
+
+		return os.WriteFile(marker, nil, 0644)
+	})
+}
+
+// GitCacheGC runs `git gc --auto` in cacheDir, which repacks and prunes only
+// once git's own gc.auto thresholds are exceeded. It does not take the mirror
+// lock GitCacheFetch uses, so it can run while another build is fetching.
+func GitCacheGC(cacheDir string) error {
+	if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+		return nil
+	}
+	return runGit(cacheDir, "gc", "--auto")
+}
+
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,94 @@
+package commands
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRefreshMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	marker := filepath.Join(dir, "mirror.git.fetched")
+
+	// cache miss: no marker yet, always due for a refresh
+	if !shouldRefreshMirror(marker, time.Hour) {
+		t.Error("expected a refresh when no marker file exists")
+	}
+
+	if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// throttle: marker is fresh, skip the fetch
+	if shouldRefreshMirror(marker, time.Hour) {
+		t.Error("expected the refresh to be throttled by a fresh marker")
+	}
+
+	// cache hit past MinPeriod: marker is stale, refresh again
+	stale := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(marker, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if !shouldRefreshMirror(marker, time.Hour) {
+		t.Error("expected a refresh once the marker is older than MinPeriod")
+	}
+}
+
+// TestWithMirrorLockExcludesConcurrentCallers covers the race GitCacheFetch
+// is exposed to when two builds of the same project start at once: without a
+// lock, both would run `git init --bare`/`git fetch` into the same mirror
+// dir concurrently.
+func TestWithMirrorLockExcludesConcurrentCallers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-cache-lock-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cacheDir := filepath.Join(dir, "mirror.git")
+
+	var current, max int32
+	var wg sync.WaitGroup
+
+	const callers = 8
+	errs := make([]error, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = withMirrorLock(cacheDir, func() error {
+				n := atomic.AddInt32(&current, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&current, -1)
+				return nil
+			})
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if max != 1 {
+		t.Errorf("expected at most 1 concurrent holder of the mirror lock, saw %d", max)
+	}
+}
@@ -0,0 +1,59 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// GitCredentialsSetupSSHKey implements the SSH-key half of the
+// `git-credentials-setup` subcommand invoked by
+// shells.AbstractShell.writeGitCredentialsSetup. It writes the SSH_PRIVATE_KEY
+// CI variable (already present in its own environment, exported by
+// writeExports) to sshKeyFile, and populates knownHostsFile from the
+// GIT_SSH_KNOWN_HOSTS CI variable, both with permissions private keys
+// require. Without GIT_SSH_KNOWN_HOSTS content, known_hosts is left empty and
+// host keys are pinned only on first connect (StrictHostKeyChecking=accept-new).
+func GitCredentialsSetupSSHKey(sshKeyFile, knownHostsFile string) error {
+	key := os.Getenv("SSH_PRIVATE_KEY")
+	if key == "" {
+		return fmt.Errorf("SSH_PRIVATE_KEY is not set")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sshKeyFile), 0700); err != nil {
+		return fmt.Errorf("creating ssh key directory: %v", err)
+	}
+	if err := os.WriteFile(sshKeyFile, []byte(key), 0600); err != nil {
+		return fmt.Errorf("writing ssh key file: %v", err)
+	}
+
+	knownHosts := os.Getenv("GIT_SSH_KNOWN_HOSTS")
+	if err := os.MkdirAll(filepath.Dir(knownHostsFile), 0700); err != nil {
+		return fmt.Errorf("creating known hosts directory: %v", err)
+	}
+	if err := os.WriteFile(knownHostsFile, []byte(knownHosts), 0600); err != nil {
+		return fmt.Errorf("writing known hosts file: %v", err)
+	}
+
+	return nil
+}
+
+// GitCredentialsSetupStore implements the credential-store half of the
+// `git-credentials-setup` subcommand. It writes a git credential-store entry
+// for host out of the GIT_CREDENTIALS_USERNAME/GIT_CREDENTIALS_PASSWORD CI
+// variables, for `git config credential.helper store --file=<credentialsFile>`
+// to pick up.
+func GitCredentialsSetupStore(credentialsFile, host string) error {
+	username := os.Getenv("GIT_CREDENTIALS_USERNAME")
+	if username == "" {
+		return fmt.Errorf("GIT_CREDENTIALS_USERNAME is not set")
+	}
+	password := os.Getenv("GIT_CREDENTIALS_PASSWORD")
+
+	if err := os.MkdirAll(filepath.Dir(credentialsFile), 0700); err != nil {
+		return fmt.Errorf("creating credentials directory: %v", err)
+	}
+
+	entry := fmt.Sprintf("https://%s:%s@%s\n", username, password, host)
+	return os.WriteFile(credentialsFile, []byte(entry), 0600)
+}
@@ -1,13 +1,22 @@
 package shells
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+	"net/url"
 	"path"
 	"strconv"
 	"strings"
 )
 
+// gitObjectCacheMinPeriod is the minimum number of seconds between two
+// fetches of the same mirror, used to avoid hammering the upstream remote
+// when many builds for the same project start at once.
+const gitObjectCacheMinPeriod = 5 * 60
+
 type AbstractShell struct {
 }
 
@@ -39,7 +48,7 @@ func (b *AbstractShell) GetFeatures(features *common.FeaturesInfo) {
 }
 
 func (s *AbstractShell) GetSupportedOptions() []string {
-	return []string{"artifacts", "cache"}
+	return []string{"artifacts", "cache", "submodules"}
 }
 
 func (b *AbstractShell) writeCdBuildDir(w ShellWriter, info common.ShellScriptInfo) {
@@ -64,31 +73,334 @@ func (b *AbstractShell) writeTLSCAInfo(w ShellWriter, build *common.Build, key s
 	}
 }
 
-func (b *AbstractShell) writeCloneCmd(w ShellWriter, build *common.Build, projectDir string) {
+// gitCredentialsDir returns the directory git credential helper files are
+// written to. It must live outside the project directory, since that gets
+// wiped by writeCloneCmd's RmDir on every clone.
+func (b *AbstractShell) gitCredentialsDir(build *common.Build) string {
+	return path.Dir(build.FullProjectDir())
+}
+
+func (b *AbstractShell) gitSSHKeyFile(build *common.Build) string {
+	return path.Join(b.gitCredentialsDir(build), ".git-credentials-ssh-key")
+}
+
+func (b *AbstractShell) gitSSHKnownHostsFile(build *common.Build) string {
+	return path.Join(b.gitCredentialsDir(build), ".git-credentials-known-hosts")
+}
+
+func (b *AbstractShell) gitCredentialsStoreFile(build *common.Build) string {
+	return path.Join(b.gitCredentialsDir(build), ".git-credentials-store")
+}
+
+// writeGitCredentialsSetup authenticates against private git remotes without
+// baking a token into RepoURL: either an SSH private key (the SSH_PRIVATE_KEY
+// CI variable) written to a keyfile and wired up via GIT_SSH_COMMAND, or a
+// username/password pair (GIT_CREDENTIALS_USERNAME/GIT_CREDENTIALS_PASSWORD)
+// written to a git credential-store file and configured as the
+// credential.helper. The runner subcommand reads the variables straight out
+// of its own environment (writeExports has already exported them) and does
+// the actual writing, so the files get the right permissions on every OS.
+func (b *AbstractShell) writeGitCredentialsSetup(w ShellWriter, build *common.Build, runnerCommand string) {
+	if runnerCommand == "" {
+		return
+	}
+
+	variables := build.GetAllVariables()
+
+	if gitVariableValue(variables, "SSH_PRIVATE_KEY") != "" {
+		keyFile := b.gitSSHKeyFile(build)
+		knownHostsFile := b.gitSSHKnownHostsFile(build)
+
+		w.Notice("Configuring SSH authentication...")
+		w.Command(runnerCommand, "git-credentials-setup",
+			"--ssh-key-file", keyFile,
+			"--known-hosts-file", knownHostsFile)
+
+		w.Variable(common.BuildVariable{
+			Key: "GIT_SSH_COMMAND",
+			Value: fmt.Sprintf("ssh -i %s -o StrictHostKeyChecking=accept-new -o UserKnownHostsFile=%s",
+				keyFile, knownHostsFile),
+			Public:   true,
+			Internal: true,
+		})
+		return
+	}
+
+	if gitVariableValue(variables, "GIT_CREDENTIALS_USERNAME") != "" {
+		credentialsFile := b.gitCredentialsStoreFile(build)
+
+		args := []string{"git-credentials-setup", "--credentials-file", credentialsFile}
+		if repoURL, err := url.Parse(build.RepoURL); err == nil && repoURL.Host != "" {
+			args = append(args, "--host", repoURL.Host)
+		}
+
+		w.Notice("Configuring git credential store...")
+		w.Command(runnerCommand, args...)
+		w.Command("git", "config", "credential.helper", "store --file="+credentialsFile)
+	}
+}
+
+// writeGitCredentialsTeardown removes any credential files written by
+// writeGitCredentialsSetup. It is unconditional and must run before any
+// early-exit branch in GeneratePostBuild, so a failed build never leaves an
+// SSH key or token lying around on the runner host.
+func (b *AbstractShell) writeGitCredentialsTeardown(w ShellWriter, build *common.Build) {
+	w.RmFile(b.gitSSHKeyFile(build))
+	w.RmFile(b.gitSSHKnownHostsFile(build))
+	w.RmFile(b.gitCredentialsStoreFile(build))
+}
+
+// gitObjectCachePath returns the path of the bare mirror used to accelerate
+// clones of build.RepoURL, namespaced by a hash of the URL so that multiple
+// projects can share the same cache directory.
+func (b *AbstractShell) gitObjectCachePath(cacheDir, repoURL string) string {
+	hash := sha256.Sum256([]byte(repoURL))
+	return path.Join(cacheDir, fmt.Sprintf("%x.git", hash))
+}
+
+// writeMirrorCmd refreshes the shared bare object cache for build.RepoURL,
+// throttled to at most once every gitObjectCacheMinPeriod seconds, and
+// returns the path clones should be made with `--reference` against. It
+// returns an empty string when no cache directory is configured, in which
+// case callers fall back to a plain clone. The cache root is an
+// admin-controlled runner setting, not job input, since GitCacheFetch will
+// create and git-init whatever path it's given.
+func (b *AbstractShell) writeMirrorCmd(w ShellWriter, build *common.Build, runnerCommand string) string {
+	cacheDir := build.Runner.GitObjectCacheDir
+	if cacheDir == "" || runnerCommand == "" {
+		return ""
+	}
+
+	mirrorDir := b.gitObjectCachePath(cacheDir, build.RepoURL)
+
+	w.Notice("Updating shared git object cache...")
+	w.Command(runnerCommand, "git-cache-fetch",
+		"--cache-dir", mirrorDir,
+		"--url", build.RepoURL,
+		"--min-period", strconv.Itoa(gitObjectCacheMinPeriod))
+
+	return mirrorDir
+}
+
+// gitCloneArgs builds the argument list for the initial `git clone`,
+// resolving the interaction between GIT_DEPTH and GIT_SINGLE_BRANCH: a
+// shallow clone defaults to `--no-single-branch` (so later deepens/fetches
+// of other branches still work), but that default must not contradict an
+// explicit `--single-branch` request.
+func (b *AbstractShell) gitCloneArgs(mirrorDir, repoURL, projectDir, refName string, depth []string, singleBranch bool) []string {
+	args := []string{"clone"}
+	if mirrorDir != "" {
+		args = append(args, "--reference", mirrorDir, "--dissociate")
+	}
+	if depth != nil {
+		args = append(args, depth...)
+		if !singleBranch {
+			args = append(args, "--no-single-branch")
+		}
+	}
+	if singleBranch {
+		args = append(args, "--single-branch", "--branch", refName)
+	}
+	return append(args, repoURL, projectDir)
+}
+
+func (b *AbstractShell) writeCloneCmd(w ShellWriter, build *common.Build, projectDir, mirrorDir string) {
 	w.Notice("Cloning repository...")
 	w.RmDir(projectDir)
-	w.Command("git", "clone", build.RepoURL, projectDir)
+
+	args := b.gitCloneArgs(mirrorDir, build.RepoURL, projectDir, build.RefName,
+		b.gitDepthArgs(build), b.gitSingleBranch(build))
+
+	w.Command("git", args...)
 	w.Cd(projectDir)
 }
 
-func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projectDir string, gitDir string) {
+func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projectDir, gitDir, mirrorDir string) {
 	w.IfDirectory(gitDir)
 	w.Notice("Fetching changes...")
 	w.Cd(projectDir)
 	w.Command("git", "clean", "-ffdx")
 	w.Command("git", "reset", "--hard")
 	w.Command("git", "remote", "set-url", "origin", build.RepoURL)
-	w.Command("git", "fetch", "origin")
+
+	// A shallow fetch must name what to fetch explicitly; git grows an
+	// existing shallow clone to the requested depth automatically. Fetching
+	// build.Sha directly, rather than refs/heads/<RefName>, works the same
+	// way for branch, tag and merge-request-style refs alike — writeCheckoutCmd
+	// checks out the SHA either way, so no named ref needs to exist locally.
+	if depth := b.gitDepthArgs(build); depth != nil {
+		args := append([]string{"fetch"}, depth...)
+		args = append(args, "origin", build.Sha)
+		w.Command("git", args...)
+	} else {
+		w.Command("git", "fetch", "origin")
+	}
+
 	w.Else()
-	b.writeCloneCmd(w, build, projectDir)
+	b.writeCloneCmd(w, build, projectDir, mirrorDir)
 	w.EndIf()
 }
 
+// writeCacheCleanupCmd has the runner subcommand run `git gc --auto` on the
+// shared git object cache after every build; git's own gc.auto thresholds
+// decide whether anything is repacked or pruned, and there is no runner-level
+// size setting. It must GC the same
+// per-repo mirror path writeMirrorCmd fetches into, not the shared cache
+// root, since that root is just a directory of mirrors and not itself a git
+// repository.
+func (b *AbstractShell) writeCacheCleanupCmd(w ShellWriter, build *common.Build, runnerCommand string) {
+	cacheDir := build.Runner.GitObjectCacheDir
+	if cacheDir == "" || runnerCommand == "" {
+		return
+	}
+
+	mirrorDir := b.gitObjectCachePath(cacheDir, build.RepoURL)
+	w.Command(runnerCommand, "git-cache-gc", "--cache-dir", mirrorDir)
+}
+
 func (b *AbstractShell) writeCheckoutCmd(w ShellWriter, build *common.Build) {
 	w.Notice("Checking out %s as %s...", build.Sha[0:8], build.RefName)
 	w.Command("git", "checkout", build.Sha)
 }
 
+// gitVariableValue returns the value of the named CI variable out of
+// variables, or "" when it isn't defined for this build. It takes a plain
+// slice rather than a *common.Build so the CI-variable lookups above it are
+// testable without needing to fake common.Build.GetAllVariables().
+func gitVariableValue(variables []common.BuildVariable, key string) string {
+	for _, variable := range variables {
+		if variable.Key == key {
+			return variable.Value
+		}
+	}
+	return ""
+}
+
+// gitVariable returns the value of the named CI variable, or "" when it
+// isn't defined for this build.
+func (b *AbstractShell) gitVariable(build *common.Build, key string) string {
+	return gitVariableValue(build.GetAllVariables(), key)
+}
+
+// gitSubmoduleStrategy returns the GIT_SUBMODULE_STRATEGY CI variable value,
+// defaulting to "none" (no submodule handling) when it isn't set.
+func (b *AbstractShell) gitSubmoduleStrategy(build *common.Build) string {
+	if strategy := b.gitVariable(build, "GIT_SUBMODULE_STRATEGY"); strategy != "" {
+		return strategy
+	}
+	return "none"
+}
+
+// gitCloneStrategy returns the GIT_CLONE_STRATEGY CI variable value
+// ("clone", "fetch" or "none"). It takes precedence over the legacy
+// AllowGitFetch toggle; when unset, AllowGitFetch continues to select
+// between "fetch" and "clone" as before.
+func (b *AbstractShell) gitCloneStrategy(build *common.Build) string {
+	if strategy := b.gitVariable(build, "GIT_CLONE_STRATEGY"); strategy != "" {
+		return strategy
+	}
+	if build.AllowGitFetch {
+		return "fetch"
+	}
+	return "clone"
+}
+
+// gitDepthArgs returns the `--depth N` argument pair for GIT_DEPTH, or nil
+// for a full clone/fetch.
+func (b *AbstractShell) gitDepthArgs(build *common.Build) []string {
+	return gitDepthArgsValue(gitVariableValue(build.GetAllVariables(), "GIT_DEPTH"))
+}
+
+// gitDepthArgsValue implements gitDepthArgs against an already-resolved
+// GIT_DEPTH value, so the depth-args logic is testable on its own.
+func gitDepthArgsValue(depth string) []string {
+	if depth == "" || depth == "0" {
+		return nil
+	}
+	return []string{"--depth", depth}
+}
+
+// gitSingleBranch reports whether GIT_SINGLE_BRANCH is enabled for this
+// build.
+func (b *AbstractShell) gitSingleBranch(build *common.Build) bool {
+	return b.gitVariable(build, "GIT_SINGLE_BRANCH") == "true"
+}
+
+// submoduleForceHTTPSConfig computes the `git config url.<base>.insteadOf
+// git@<host>:` rewrite that makes submodule fetches reuse the scheme,
+// credentials and host of repoURL, so that tokens injected into it also apply
+// to submodules. ok is false when repoURL can't be parsed into a usable base.
+func submoduleForceHTTPSConfig(repoURL string) (key, value string, ok bool) {
+	parsed, err := url.Parse(repoURL)
+	if err != nil || parsed.Host == "" {
+		return "", "", false
+	}
+
+	base := parsed.Host
+	if parsed.User != nil {
+		base = parsed.User.String() + "@" + base
+	}
+
+	key = fmt.Sprintf("url.%s://%s/.insteadOf", parsed.Scheme, base)
+	value = fmt.Sprintf("git@%s:", parsed.Host)
+	return key, value, true
+}
+
+// writeSubmoduleForceHTTPSCmd rewrites submodule URLs to use the scheme,
+// credentials and host of build.RepoURL, so that tokens injected into RepoURL also apply
+// when git fetches submodules.
+func (b *AbstractShell) writeSubmoduleForceHTTPSCmd(w ShellWriter, build *common.Build) {
+	key, value, ok := submoduleForceHTTPSConfig(build.RepoURL)
+	if !ok {
+		return
+	}
+
+	w.Command("git", "config", key, value)
+}
+
+func (b *AbstractShell) writeSubmoduleCmd(w ShellWriter, build *common.Build) {
+	strategy := b.gitSubmoduleStrategy(build)
+	if strategy == "none" || strategy == "" {
+		return
+	}
+
+	recursive := strategy == "recursive"
+
+	for _, variable := range build.GetAllVariables() {
+		if variable.Key == "GIT_SUBMODULE_FORCE_HTTPS" && variable.Value == "true" {
+			b.writeSubmoduleForceHTTPSCmd(w, build)
+		}
+	}
+
+	// Submodule fetches are separate git invocations from the main
+	// clone/fetch, so they need their own GIT_SSL_CAINFO/CI_SERVER_TLS_CA_FILE
+	// export to trust a custom CA when build.TLSCAChain is set.
+	b.writeTLSCAInfo(w, build, "GIT_SSL_CAINFO")
+	b.writeTLSCAInfo(w, build, "CI_SERVER_TLS_CA_FILE")
+
+	w.Notice("Updating/initializing submodules...")
+
+	if recursive {
+		w.Command("git", "submodule", "sync", "--recursive")
+	} else {
+		w.Command("git", "submodule", "sync")
+	}
+
+	if recursive {
+		w.Command("git", "submodule", "foreach", "--recursive", "git", "clean", "-ffdx")
+		w.Command("git", "submodule", "foreach", "--recursive", "git", "reset", "--hard")
+	} else {
+		w.Command("git", "submodule", "foreach", "git", "clean", "-ffdx")
+		w.Command("git", "submodule", "foreach", "git", "reset", "--hard")
+	}
+
+	if recursive {
+		w.Command("git", "submodule", "update", "--init", "--recursive")
+	} else {
+		w.Command("git", "submodule", "update", "--init")
+	}
+}
+
 func (b *AbstractShell) GeneratePreBuild(w ShellWriter, info common.ShellScriptInfo) {
 	b.writeExports(w, info)
 
@@ -96,16 +408,27 @@ func (b *AbstractShell) GeneratePreBuild(w ShellWriter, info common.ShellScriptI
 	projectDir := build.FullProjectDir()
 	gitDir := path.Join(build.FullProjectDir(), ".git")
 
+	b.writeGitCredentialsSetup(w, build, info.RunnerCommand)
+
 	b.writeTLSCAInfo(w, info.Build, "GIT_SSL_CAINFO")
 	b.writeTLSCAInfo(w, info.Build, "CI_SERVER_TLS_CA_FILE")
 
-	if build.AllowGitFetch {
-		b.writeFetchCmd(w, build, projectDir, gitDir)
-	} else {
-		b.writeCloneCmd(w, build, projectDir)
+	// GIT_CLONE_STRATEGY=none assumes the executor already provisioned the
+	// working tree (e.g. a persistent volume) and skips clone/fetch (and the
+	// mirror refresh that only exists to feed one) entirely.
+	switch strategy := b.gitCloneStrategy(build); strategy {
+	case "none":
+		w.Cd(projectDir)
+	case "clone":
+		mirrorDir := b.writeMirrorCmd(w, build, info.RunnerCommand)
+		b.writeCloneCmd(w, build, projectDir, mirrorDir)
+	default:
+		mirrorDir := b.writeMirrorCmd(w, build, info.RunnerCommand)
+		b.writeFetchCmd(w, build, projectDir, gitDir, mirrorDir)
 	}
 
 	b.writeCheckoutCmd(w, build)
+	b.writeSubmoduleCmd(w, build)
 
 	cacheFile := info.Build.CacheFile()
 	cacheFile2 := info.Build.CacheFileForRef("master")
@@ -114,17 +437,19 @@ func (b *AbstractShell) GeneratePreBuild(w ShellWriter, info common.ShellScriptI
 		cacheFile2 = ""
 	}
 
+	remoteURL := b.cacheObjectBaseURL(build)
+
 	// Try to restore from main cache, if not found cache for master
 	if cacheFile != "" {
 		// If we have cache, restore it
 		w.IfFile(cacheFile)
-		b.extractFiles(w, info.RunnerCommand, "cache", cacheFile)
+		b.extractFiles(w, info.RunnerCommand, "cache", cacheFile, remoteURL, b.cacheObjectKey(build, build.RefName, cacheFile))
 		if cacheFile2 != "" {
 			w.Else()
 
 			// If we have cache, restore it
 			w.IfFile(cacheFile2)
-			b.extractFiles(w, info.RunnerCommand, "cache", cacheFile2)
+			b.extractFiles(w, info.RunnerCommand, "cache", cacheFile2, remoteURL, b.cacheObjectKey(build, "master", cacheFile2))
 			w.EndIf()
 		}
 		w.EndIf()
@@ -137,7 +462,8 @@ func (b *AbstractShell) GeneratePreBuild(w ShellWriter, info common.ShellScriptI
 		}
 
 		b.downloadArtifacts(w, info.Build.Runner, &otherBuild, info.RunnerCommand, otherBuild.Artifacts.Filename)
-		b.extractFiles(w, info.RunnerCommand, otherBuild.Name, otherBuild.Artifacts.Filename)
+		b.verifyArtifactsChecksum(w, info.RunnerCommand, otherBuild.Artifacts.Filename)
+		b.extractFiles(w, info.RunnerCommand, otherBuild.Name, otherBuild.Artifacts.Filename, "", "")
 		w.RmFile(otherBuild.Artifacts.Filename)
 	}
 }
@@ -161,7 +487,42 @@ func (b *AbstractShell) GenerateCommands(w ShellWriter, info common.ShellScriptI
 	}
 }
 
-func (b *AbstractShell) archiveFiles(w ShellWriter, list interface{}, runnerCommand, archiveType, archivePath string) {
+// cacheObjectKey derives the remote object key a local cache file is
+// archived under, templated as "{project}/{ref}/{cache-key}" so that cache
+// reuse across branches stays opt-in, the same way CacheFileForRef("master")
+// already opts in to reuse across refs for the local backend.
+func (b *AbstractShell) cacheObjectKey(build *common.Build, ref, cacheFile string) string {
+	return cache.KeyForRef(path.Base(build.FullProjectDir()), ref, path.Base(cacheFile))
+}
+
+// cacheObjectBaseURL returns the CACHE_REMOTE_URL CI variable (a s3:// or
+// gs:// bucket URL), or "" when no remote cache backend is configured, in
+// which case callers fall back to the local, file-based archive.
+func (b *AbstractShell) cacheObjectBaseURL(build *common.Build) string {
+	return strings.TrimRight(b.gitVariable(build, "CACHE_REMOTE_URL"), "/")
+}
+
+// artifactsFormats lists the archive formats the "artifacts" runner
+// subcommand knows how to produce, chosen via the artifacts.format option
+// (parallel to how repository archive downloads offer multiple formats).
+var artifactsFormats = map[string]bool{"zip": true, "tar": true, "tar.gz": true, "tar.zst": true}
+
+// artifactsFormat returns the configured artifacts.format option, defaulting
+// to "zip" when unset or invalid.
+func (b *AbstractShell) artifactsFormat(build *common.Build) string {
+	if hash, ok := helpers.ToConfigMap(build.Options["artifacts"]); ok {
+		if format, ok := hash["format"].(string); ok && artifactsFormats[format] {
+			return format
+		}
+	}
+	return "zip"
+}
+
+// archiveFiles builds the archive at archivePath and, when remoteURL is set,
+// has the runner subcommand upload it under key to the s3:// or gs:// backend
+// cache.FromURL resolves remoteURL to. When checksum is set, the subcommand
+// also writes a "<archivePath>.sha256" sidecar once the archive is complete.
+func (b *AbstractShell) archiveFiles(w ShellWriter, list interface{}, runnerCommand, archiveType, archivePath, remoteURL, key, format string, checksum bool) {
 	hash, ok := helpers.ToConfigMap(list)
 	if !ok {
 		return
@@ -197,12 +558,24 @@ func (b *AbstractShell) archiveFiles(w ShellWriter, list interface{}, runnerComm
 		return
 	}
 
+	if format != "" {
+		args = append(args, "--format", format)
+	}
+
+	if remoteURL != "" {
+		args = append(args, "--url", remoteURL, "--key", key)
+	}
+
+	if checksum {
+		args = append(args, "--checksum")
+	}
+
 	// Execute archive command
 	w.Notice("Archiving %s...", archiveType)
 	w.Command(runnerCommand, args...)
 }
 
-func (b *AbstractShell) extractFiles(w ShellWriter, runnerCommand, archiveType, archivePath string) {
+func (b *AbstractShell) extractFiles(w ShellWriter, runnerCommand, archiveType, archivePath, remoteURL, key string) {
 	if runnerCommand == "" {
 		w.Warning("The %s is not supported in this executor.", archiveType)
 		return
@@ -214,11 +587,20 @@ func (b *AbstractShell) extractFiles(w ShellWriter, runnerCommand, archiveType,
 		archivePath,
 	}
 
+	if remoteURL != "" {
+		args = append(args, "--url", remoteURL, "--key", key)
+	}
+
 	// Execute extract command
 	w.Notice("Restoring %s...", archiveType)
 	w.Command(runnerCommand, args...)
 }
 
+// downloadArtifacts fetches the archive at archivePath plus its
+// "<archivePath>.sha256" sidecar, if the uploading build produced one, so
+// verifyArtifactsChecksum has something to check it against. The sidecar is
+// requested best-effort: the runner subcommand only writes it if the server
+// actually has one for this build.
 func (b *AbstractShell) downloadArtifacts(w ShellWriter, runner *common.RunnerConfig, build *common.BuildInfo, runnerCommand, archivePath string) {
 	if runnerCommand == "" {
 		w.Warning("The artifacts downloading is not supported in this executor.")
@@ -236,13 +618,34 @@ func (b *AbstractShell) downloadArtifacts(w ShellWriter, runner *common.RunnerCo
 		strconv.Itoa(build.ID),
 		"--file",
 		archivePath,
+		"--checksum",
+		archivePath + ".sha256",
 	}
 
 	w.Notice("Downloading artifacts for %s (%d)...", build.Name, build.ID)
 	w.Command(runnerCommand, args...)
 }
 
-func (b *AbstractShell) uploadArtifacts(w ShellWriter, build *common.Build, runnerCommand, archivePath string) {
+// verifyArtifactsChecksum checks a downloaded archive against its sidecar
+// sha256, when one was fetched alongside it. Verification runs through the
+// runner subcommand, rather than a `sha256sum`-like binary, so it works the
+// same on every executor OS.
+func (b *AbstractShell) verifyArtifactsChecksum(w ShellWriter, runnerCommand, archivePath string) {
+	if runnerCommand == "" {
+		return
+	}
+
+	checksumFile := archivePath + ".sha256"
+	w.IfFile(checksumFile)
+	w.Command(runnerCommand, "artifacts-verify", "--file", archivePath, "--sha256", checksumFile)
+	w.EndIf()
+}
+
+// uploadArtifacts uploads the archive at archivePath. checksum must only be
+// true when archiveFiles was asked to produce the "<archivePath>.sha256"
+// sidecar for this same archive; otherwise the runner subcommand is handed a
+// path to a checksum file that doesn't exist.
+func (b *AbstractShell) uploadArtifacts(w ShellWriter, build *common.Build, runnerCommand, archivePath string, checksum bool) {
 	if runnerCommand == "" {
 		w.Warning("The artifacts uploading is not supported in this executor.")
 		return
@@ -260,28 +663,41 @@ func (b *AbstractShell) uploadArtifacts(w ShellWriter, build *common.Build, runn
 		archivePath,
 	}
 
+	if checksum {
+		args = append(args, "--checksum", archivePath+".sha256")
+	}
+
 	w.Notice("Uploading artifacts...")
 	w.Command(runnerCommand, args...)
 }
 
 func (b *AbstractShell) GeneratePostBuild(w ShellWriter, info common.ShellScriptInfo) {
+	b.writeGitCredentialsTeardown(w, info.Build)
+
 	b.writeExports(w, info)
 	b.writeCdBuildDir(w, info)
 	b.writeTLSCAInfo(w, info.Build, "CI_SERVER_TLS_CA_FILE")
 
 	// Find cached files and archive them
 	if cacheFile := info.Build.CacheFile(); cacheFile != "" {
-		b.archiveFiles(w, info.Build.Options["cache"], info.RunnerCommand, "cache", cacheFile)
+		remoteURL := b.cacheObjectBaseURL(info.Build)
+		b.archiveFiles(w, info.Build.Options["cache"], info.RunnerCommand, "cache", cacheFile, remoteURL, b.cacheObjectKey(info.Build, info.Build.RefName, cacheFile), "", false)
 	}
 
 	if info.Build.Network != nil {
-		// Find artifacts
-		b.archiveFiles(w, info.Build.Options["artifacts"], info.RunnerCommand, "artifacts", "artifacts.zip")
+		format := b.artifactsFormat(info.Build)
+		archivePath := "artifacts." + format
+
+		// Find artifacts; always have the archive step emit a sha256 sidecar,
+		// since the upload right below always asks for one.
+		b.archiveFiles(w, info.Build.Options["artifacts"], info.RunnerCommand, "artifacts", archivePath, "", "", format, true)
 
 		// If archive is created upload it
-		w.IfFile("artifacts.zip")
-		b.uploadArtifacts(w, info.Build, info.RunnerCommand, "artifacts.zip")
-		w.RmFile("aritfacts.zip")
+		w.IfFile(archivePath)
+		b.uploadArtifacts(w, info.Build, info.RunnerCommand, archivePath, true)
+		w.RmFile(archivePath)
 		w.EndIf()
 	}
+
+	b.writeCacheCleanupCmd(w, info.Build, info.RunnerCommand)
 }
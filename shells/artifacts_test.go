@@ -0,0 +1,92 @@
+package shells
+
+import (
+	"testing"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+// TestDownloadArtifactsFetchesChecksumSidecar covers the bug where
+// downloadArtifacts never asked for the "<archivePath>.sha256" sidecar, so
+// verifyArtifactsChecksum's w.IfFile guard never found anything to verify.
+func TestDownloadArtifactsFetchesChecksumSidecar(t *testing.T) {
+	w := &recordingShellWriter{}
+	shell := &AbstractShell{}
+	runner := &common.RunnerConfig{}
+	build := &common.BuildInfo{Token: "token", ID: 1}
+
+	shell.downloadArtifacts(w, runner, build, "gitlab-runner", "artifacts.zip")
+
+	if !w.has("--checksum") || !w.has("artifacts.zip.sha256") {
+		t.Errorf("expected downloadArtifacts to request the checksum sidecar, got calls: %v", w.calls)
+	}
+}
+
+func TestArchiveFilesChecksumFlag(t *testing.T) {
+	list := map[string]interface{}{"paths": []interface{}{"build/"}}
+
+	t.Run("checksum requested", func(t *testing.T) {
+		w := &recordingShellWriter{}
+		shell := &AbstractShell{}
+
+		shell.archiveFiles(w, list, "gitlab-runner", "artifacts", "artifacts.zip", "", "", "zip", true)
+
+		if !w.has("--checksum") {
+			t.Error("expected --checksum when checksum is requested")
+		}
+	})
+
+	t.Run("checksum not requested", func(t *testing.T) {
+		w := &recordingShellWriter{}
+		shell := &AbstractShell{}
+
+		shell.archiveFiles(w, list, "gitlab-runner", "cache", "cache.zip", "", "", "", false)
+
+		if w.has("--checksum") {
+			t.Error("expected no --checksum when checksum is not requested")
+		}
+	})
+}
+
+func TestArchiveFilesRemoteURL(t *testing.T) {
+	list := map[string]interface{}{"paths": []interface{}{"build/"}}
+	w := &recordingShellWriter{}
+	shell := &AbstractShell{}
+
+	shell.archiveFiles(w, list, "gitlab-runner", "cache", "cache.zip", "s3://bucket", "project/master/cache.zip", "", false)
+
+	if !w.has("--url") || !w.has("s3://bucket") || !w.has("project/master/cache.zip") {
+		t.Errorf("expected the remote url and key to be passed through, got calls: %v", w.calls)
+	}
+}
+
+// TestUploadArtifactsChecksumGuard covers the bug where uploadArtifacts
+// always pointed the runner subcommand at a "<archivePath>.sha256" sidecar
+// that archiveFiles never asked to be produced: the checksum flag on each
+// side must always agree.
+func TestUploadArtifactsChecksumGuard(t *testing.T) {
+	build := &common.Build{
+		Runner: &common.RunnerConfig{},
+		Token:  "token",
+		ID:     1,
+	}
+	shell := &AbstractShell{}
+
+	t.Run("checksum produced by archive step", func(t *testing.T) {
+		w := &recordingShellWriter{}
+		shell.uploadArtifacts(w, build, "gitlab-runner", "artifacts.zip", true)
+
+		if !w.has("--checksum") || !w.has("artifacts.zip.sha256") {
+			t.Errorf("expected a --checksum pointing at the sidecar, got calls: %v", w.calls)
+		}
+	})
+
+	t.Run("no checksum produced", func(t *testing.T) {
+		w := &recordingShellWriter{}
+		shell.uploadArtifacts(w, build, "gitlab-runner", "artifacts.zip", false)
+
+		if w.has("--checksum") {
+			t.Error("expected no --checksum arg when no sidecar was produced")
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package shells
+
+import (
+	"testing"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func TestGitVariableValue(t *testing.T) {
+	variables := []common.BuildVariable{
+		{Key: "GIT_DEPTH", Value: "50"},
+		{Key: "GIT_SINGLE_BRANCH", Value: "true"},
+	}
+
+	if v := gitVariableValue(variables, "GIT_DEPTH"); v != "50" {
+		t.Errorf("expected GIT_DEPTH to be %q, got %q", "50", v)
+	}
+	if v := gitVariableValue(variables, "GIT_CLONE_STRATEGY"); v != "" {
+		t.Errorf("expected an unset variable to resolve to \"\", got %q", v)
+	}
+}
+
+func TestGitDepthArgsValue(t *testing.T) {
+	cases := []struct {
+		depth string
+		want  []string
+	}{
+		{"", nil},
+		{"0", nil},
+		{"50", []string{"--depth", "50"}},
+	}
+
+	for _, c := range cases {
+		got := gitDepthArgsValue(c.depth)
+		if !equalStrings(got, c.want) {
+			t.Errorf("gitDepthArgsValue(%q) = %v, want %v", c.depth, got, c.want)
+		}
+	}
+}
+
+// TestGitCloneArgs covers the depth x single-branch interaction matrix: a
+// shallow clone defaults to --no-single-branch, but must not also contradict
+// an explicit GIT_SINGLE_BRANCH=true request.
+func TestGitCloneArgs(t *testing.T) {
+	shell := &AbstractShell{}
+
+	cases := []struct {
+		name         string
+		mirrorDir    string
+		depth        []string
+		singleBranch bool
+		want         []string
+	}{
+		{
+			name: "full clone, no mirror",
+			want: []string{"clone", "repo", "dir"},
+		},
+		{
+			name:      "full clone, with mirror",
+			mirrorDir: "/cache/repo.git",
+			want:      []string{"clone", "--reference", "/cache/repo.git", "--dissociate", "repo", "dir"},
+		},
+		{
+			name:  "shallow clone, single branch not requested",
+			depth: []string{"--depth", "1"},
+			want:  []string{"clone", "--depth", "1", "--no-single-branch", "repo", "dir"},
+		},
+		{
+			name:         "shallow clone, single branch requested",
+			depth:        []string{"--depth", "1"},
+			singleBranch: true,
+			want:         []string{"clone", "--depth", "1", "--single-branch", "--branch", "master", "repo", "dir"},
+		},
+		{
+			name:         "full clone, single branch requested",
+			singleBranch: true,
+			want:         []string{"clone", "--single-branch", "--branch", "master", "repo", "dir"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := shell.gitCloneArgs(c.mirrorDir, "repo", "dir", "master", c.depth, c.singleBranch)
+			if !equalStrings(got, c.want) {
+				t.Errorf("gitCloneArgs() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
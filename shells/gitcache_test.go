@@ -0,0 +1,17 @@
+package shells
+
+import "testing"
+
+func TestGitObjectCachePath(t *testing.T) {
+	shell := &AbstractShell{}
+
+	a := shell.gitObjectCachePath("/cache", "https://example.com/a.git")
+	b := shell.gitObjectCachePath("/cache", "https://example.com/b.git")
+
+	if a == b {
+		t.Errorf("expected different repos to hash to different cache paths, got %q for both", a)
+	}
+	if shell.gitObjectCachePath("/cache", "https://example.com/a.git") != a {
+		t.Error("expected gitObjectCachePath to be deterministic for the same URL")
+	}
+}
@@ -0,0 +1,57 @@
+package shells
+
+import (
+	"fmt"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+// recordingShellWriter is a minimal ShellWriter stub that just records every
+// call made against it, so tests can assert on the exact command sequence
+// AbstractShell emits.
+type recordingShellWriter struct {
+	calls []string
+}
+
+func (r *recordingShellWriter) record(format string, args ...interface{}) {
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingShellWriter) Variable(variable common.BuildVariable) {
+	r.record("Variable(%s=%s)", variable.Key, variable.Value)
+}
+func (r *recordingShellWriter) Command(command string, arguments ...string) {
+	r.record("Command(%s %v)", command, arguments)
+}
+func (r *recordingShellWriter) Line(text string)            { r.record("Line(%s)", text) }
+func (r *recordingShellWriter) IfDirectory(path string)     { r.record("IfDirectory(%s)", path) }
+func (r *recordingShellWriter) IfFile(file string)           { r.record("IfFile(%s)", file) }
+func (r *recordingShellWriter) Else()                        { r.record("Else()") }
+func (r *recordingShellWriter) EndIf()                       { r.record("EndIf()") }
+func (r *recordingShellWriter) Cd(path string)                { r.record("Cd(%s)", path) }
+func (r *recordingShellWriter) RmDir(path string)             { r.record("RmDir(%s)", path) }
+func (r *recordingShellWriter) RmFile(path string)            { r.record("RmFile(%s)", path) }
+func (r *recordingShellWriter) Absolute(path string) string   { return path }
+func (r *recordingShellWriter) Print(format string, arguments ...interface{}) {
+	r.record("Print("+format+")", arguments...)
+}
+func (r *recordingShellWriter) Notice(format string, arguments ...interface{}) {
+	r.record("Notice("+format+")", arguments...)
+}
+func (r *recordingShellWriter) Warning(format string, arguments ...interface{}) {
+	r.record("Warning("+format+")", arguments...)
+}
+func (r *recordingShellWriter) Error(format string, arguments ...interface{}) {
+	r.record("Error("+format+")", arguments...)
+}
+func (r *recordingShellWriter) EmptyLine() { r.record("EmptyLine()") }
+
+func (r *recordingShellWriter) has(substr string) bool {
+	for _, call := range r.calls {
+		if strings.Contains(call, substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package shells
+
+import "testing"
+
+func TestSubmoduleForceHTTPSConfig(t *testing.T) {
+	key, value, ok := submoduleForceHTTPSConfig("https://gitlab.example.com/group/project.git")
+	if !ok {
+		t.Fatal("expected a valid config rewrite for a well-formed URL")
+	}
+	if key != "url.https://gitlab.example.com/.insteadOf" {
+		t.Errorf("unexpected config key: %q", key)
+	}
+	if value != "git@gitlab.example.com:" {
+		t.Errorf("unexpected config value: %q", value)
+	}
+
+	if _, _, ok := submoduleForceHTTPSConfig("not a url"); ok {
+		t.Error("expected an unparsable repo URL to be rejected")
+	}
+	if _, _, ok := submoduleForceHTTPSConfig(""); ok {
+		t.Error("expected an empty repo URL to be rejected")
+	}
+}